@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(*toJson(obj))
+}
+
+func httpMethodOp(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "Setup"
+	case http.MethodDelete:
+		return "Delete"
+	default:
+		return "GetPort"
+	}
+}
+
+func tenantsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/tenants/")
+
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("Panic stopped: %v", e)
+			writeJSON(w, http.StatusInternalServerError, &PlainResponse{Status: "ERROR", Error: panicMessage(e)})
+		}
+	}()
+
+	if id == "" || idRe.MatchString(id) == false {
+		writeJSON(w, http.StatusBadRequest, &PlainResponse{Status: "ERROR", Error: "Invalid id."})
+		return
+	}
+
+	if isMutatingOp(httpMethodOp(r.Method)) && !isLeader() {
+		writeJSON(w, http.StatusServiceUnavailable, &PlainResponse{Status: "ERROR", Error: "not leader"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		meta := TenantMeta{Id: id}
+		if r.ContentLength != 0 {
+			if e := json.NewDecoder(r.Body).Decode(&meta); e != nil {
+				writeJSON(w, http.StatusBadRequest, &PlainResponse{Status: "ERROR", Error: "Invalid request body."})
+				return
+			}
+		}
+
+		rsp := &SetupResponse{Status: "OK", Id: id}
+		port, e := setupInstance(id, meta)
+		if e != nil {
+			rsp.Status = "ERROR"
+			rsp.Error = e.Error()
+		} else {
+			rsp.Port = port
+			rsp.MaxMemory = meta.MaxMemory
+			rsp.MaxClients = meta.MaxClients
+			rsp.TTL = meta.TTL
+			rsp.Labels = meta.Labels
+		}
+
+		writeJSON(w, http.StatusOK, rsp)
+
+	case http.MethodDelete:
+		rsp := &PlainResponse{Status: "OK", Id: id}
+		if e := deleteInstance(id); e != nil {
+			rsp.Status = "ERROR"
+			rsp.Error = e.Error()
+		}
+
+		writeJSON(w, http.StatusOK, rsp)
+
+	case http.MethodGet:
+		rsp := &SetupResponse{Status: "OK", Id: id}
+		port, e := getExistingPort(id)
+		if e != nil {
+			rsp.Status = "ERROR"
+			rsp.Error = e.Error()
+		} else {
+			rsp.Port = port
+		}
+
+		writeJSON(w, http.StatusOK, rsp)
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, &PlainResponse{Status: "ERROR", Error: "Unknown operation."})
+	}
+}
+
+func listTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("Panic stopped: %v", e)
+			writeJSON(w, http.StatusInternalServerError, &ListResponse{Status: "ERROR", Error: panicMessage(e)})
+		}
+	}()
+
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &PlainResponse{Status: "ERROR", Error: "Unknown operation."})
+		return
+	}
+
+	rsp := &ListResponse{Status: "OK"}
+	tenants, e := listTenants()
+	if e != nil {
+		rsp.Status = "ERROR"
+		rsp.Error = e.Error()
+	} else {
+		rsp.Tenants = tenants
+	}
+
+	writeJSON(w, http.StatusOK, rsp)
+}
+
+// listenHTTP runs the HTTP control-plane API alongside the Pub/Sub
+// interface, so clients like sidecars, CI scripts, or k8s operators can
+// manage tenants, and load balancers can health-check the landlord. It
+// shuts down along with listen() when ctx is cancelled.
+func listenHTTP() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenants", listTenantsHandler)
+	mux.HandleFunc("/tenants/", tenantsHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:    ":" + strconv.Itoa(cfg.ListenPort),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down: stopping HTTP server")
+		if e := srv.Shutdown(context.Background()); e != nil {
+			log.Printf("HTTP server shutdown: %v", e)
+		}
+	}()
+
+	log.Printf("Listening for HTTP on %s", srv.Addr)
+	if e := srv.ListenAndServe(); e != nil && e != http.ErrServerClosed {
+		log.Panicf("HTTP server failed: %v", e)
+	}
+}
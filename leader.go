@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/redis/go-redis/v9"
+	"log"
+	"sync"
+	"time"
+)
+
+var leaderMu sync.RWMutex
+var leading bool
+
+var leaderRenewScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		return 1
+	else
+		return 0
+	end
+`)
+
+func isLeader() bool {
+	leaderMu.RLock()
+	defer leaderMu.RUnlock()
+	return leading
+}
+
+// leaseHeld reports whether some node currently holds the leader lease,
+// so a non-leader can tell an election gap (nobody to forward to) apart
+// from a known leader simply being someone else.
+func leaseHeld() bool {
+	n, e := rdb.Exists(ctx, getKey("leader")).Result()
+	if e != nil {
+		log.Printf("Leader election: unable to check lease: %v", e)
+		return false
+	}
+
+	return n > 0
+}
+
+// gapReplyTTL only needs to outlive the fan-out of a single forwarded
+// instruction across nodes, not the lease itself.
+const gapReplyTTL = 5 * time.Second
+
+// claimGapReply reports whether the caller is the first node to observe a
+// given request landing in the leader-election gap. landlord.leader.request
+// delivers a forwarded instruction to every non-leader node at once, so
+// without this every one of them would independently reply to the client.
+func claimGapReply(requestId string) bool {
+	ok, e := rdb.SetNX(ctx, getKey("gap-reply", requestId), cfg.NodeId, gapReplyTTL).Result()
+	if e != nil {
+		log.Printf("Leader election: unable to claim gap-reply marker for %s: %v", requestId, e)
+		return true
+	}
+
+	return ok
+}
+
+func setLeader(v bool) (becameLeader bool) {
+	leaderMu.Lock()
+	becameLeader = v && !leading
+	leading = v
+	leaderMu.Unlock()
+
+	return becameLeader
+}
+
+// tryAcquireOrRenew runs one SET NX PX / Redlock-style lease attempt
+// against the shared "landlord:leader" key, following the renew-if-ours,
+// acquire-if-free pattern so only one Landlord node at a time performs
+// mutating operations.
+func tryAcquireOrRenew(lease time.Duration) {
+	acquired, e := rdb.SetNX(ctx, getKey("leader"), cfg.NodeId, lease).Result()
+	if e != nil {
+		log.Printf("Leader election: unable to acquire lock: %v", e)
+		setLeader(false)
+		return
+	}
+
+	if acquired {
+		// Populate/reconcile ports:possible and the tenant set before
+		// isLeader() goes true, so no concurrently-dispatched op can
+		// observe leadership while the DB is still unprepared.
+		prepareDb()
+
+		if setLeader(true) {
+			log.Printf("Became leader (%s)", cfg.NodeId)
+			rdb.Publish(ctx, "landlord.leader", cfg.NodeId)
+		}
+
+		return
+	}
+
+	renewed, e := leaderRenewScript.Run(ctx, rdb, []string{getKey("leader")}, cfg.NodeId, lease.Milliseconds()).Int()
+	if e != nil {
+		log.Printf("Leader election: unable to renew lock: %v", e)
+		setLeader(false)
+		return
+	}
+
+	if renewed == 1 {
+		setLeader(true)
+	} else {
+		setLeader(false)
+	}
+}
+
+// startLeaderElection runs the lease acquire/renew loop for as long as
+// the process lives.
+func startLeaderElection() {
+	lease := time.Duration(cfg.LeaderLeaseMs) * time.Millisecond
+	interval := time.Duration(cfg.LeaderRenewMs) * time.Millisecond
+
+	tryAcquireOrRenew(lease)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tryAcquireOrRenew(lease)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var logger = logrus.New()
+
+func init() {
+	logger.SetFormatter(&logrus.JSONFormatter{})
+}
+
+func errString(e error) string {
+	if e == nil {
+		return ""
+	}
+
+	return e.Error()
+}
+
+// opLog emits one structured record per tenant operation, with the fields
+// an operator needs to spot port exhaustion, manager failures or slow
+// setups without grepping log files.
+func opLog(op string, tenantId string, start time.Time, port int, err error) {
+	entry := logger.WithFields(logrus.Fields{
+		"op":          op,
+		"tenant_id":   tenantId,
+		"port":        port,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"err":         errString(err),
+	})
+
+	if err != nil {
+		entry.Error(op)
+	} else {
+		entry.Info(op)
+	}
+}
@@ -2,29 +2,44 @@ package main
 
 import (
 	"bitbucket.org/kardianos/osext"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/garyburd/redigo/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type Cfg struct {
-	ManagerPath    string
-	ListenPort     int
-	LandlordPort   int
-	TenantPortBase int
-	MaxTenants     int
-	LogPath        string
+	ManagerPath         string
+	ListenPort          int
+	LandlordPort        int
+	TenantPortBase      int
+	MaxTenants          int
+	LogPath             string
+	RedisAddrs          []string
+	RedisSentinelMaster string
+	RedisUsername       string
+	RedisPassword       string
+	RedisDB             int
+	RedisTLS            bool
+	NodeId              string
+	LeaderLeaseMs       int
+	LeaderRenewMs       int
 }
 
 type ManagerError struct {
@@ -37,25 +52,59 @@ func (e *ManagerError) Error() string {
 }
 
 type Instruction struct {
-	ReplyTo string
-	Op      string
-	Id      string
+	ReplyTo    string
+	RequestId  string
+	Op         string
+	Id         string
+	MaxMemory  int64
+	MaxClients int
+	TTL        int
+	Labels     map[string]string
 }
 
 type PlainResponse struct {
-	Id     string
-	Status string
-	Error  string
+	Id        string
+	RequestId string
+	Status    string
+	Error     string
 }
 
 type SetupResponse struct {
-	Id     string
-	Status string
-	Error  string
-	Port   int
+	Id         string
+	RequestId  string
+	Status     string
+	Error      string
+	Port       int
+	MaxMemory  int64             `json:",omitempty"`
+	MaxClients int               `json:",omitempty"`
+	TTL        int               `json:",omitempty"`
+	Labels     map[string]string `json:",omitempty"`
+}
+
+type TenantMeta struct {
+	Id         string
+	Port       int
+	MaxMemory  int64
+	MaxClients int
+	TTL        int
+	Labels     map[string]string
+}
+
+type ListResponse struct {
+	RequestId string
+	Status    string
+	Error     string
+	Tenants   []TenantMeta
 }
 
 var cfg Cfg
+var ctx, cancelCtx = context.WithCancel(context.Background())
+var handlerWg sync.WaitGroup
+
+// bgWg tracks the reaper and leader-election background loops, so main()
+// can wait for their current iteration to finish before the process exits.
+var bgWg sync.WaitGroup
+var rdb redis.UniversalClient
 var idRe = regexp.MustCompile(`^[_\-a-zA-Z0-9]+$`)
 var errRe = regexp.MustCompile(`^exit status ([0-9]+)$`)
 var managerErrors = map[int]string{
@@ -100,6 +149,26 @@ func readConfig() *Cfg {
 		cfg.MaxTenants = 10
 	}
 
+	if cfg.RedisPassword == "" {
+		cfg.RedisPassword = "landlord"
+	}
+
+	if cfg.NodeId == "" {
+		if host, e := os.Hostname(); e == nil {
+			cfg.NodeId = host + ":" + strconv.Itoa(os.Getpid())
+		} else {
+			cfg.NodeId = strconv.Itoa(os.Getpid())
+		}
+	}
+
+	if cfg.LeaderLeaseMs <= 0 {
+		cfg.LeaderLeaseMs = 10000
+	}
+
+	if cfg.LeaderRenewMs <= 0 {
+		cfg.LeaderRenewMs = 3000
+	}
+
 	if cfg.LogPath == "" {
 		cfg.LogPath =
 			func() string {
@@ -124,81 +193,122 @@ func toJson(obj interface{}) *[]byte {
 	return &r
 }
 
-func dial() *redis.Conn {
-	c, e := redis.Dial("tcp", ":"+strconv.Itoa(cfg.LandlordPort))
-	if e != nil {
-		log.Panicf("Dialling error: %v", e)
+func redisTLSConfig(c *Cfg) *tls.Config {
+	if !c.RedisTLS {
+		return nil
 	}
 
-	if _, e := c.Do("AUTH", "landlord"); e != nil {
-		log.Panicf("AUTH error: %v", e)
-	}
+	return &tls.Config{}
+}
+
+// newRedisClient builds the client used to talk to the landlord Redis
+// deployment. A sentinel master name yields a failover client, more than
+// one address yields a cluster client, and otherwise a single-node client
+// is used, falling back to the legacy ":LandlordPort" address.
+func newRedisClient(c *Cfg) redis.UniversalClient {
+	switch {
+	case c.RedisSentinelMaster != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    c.RedisSentinelMaster,
+			SentinelAddrs: c.RedisAddrs,
+			Username:      c.RedisUsername,
+			Password:      c.RedisPassword,
+			DB:            c.RedisDB,
+			TLSConfig:     redisTLSConfig(c),
+		})
+
+	case len(c.RedisAddrs) > 1:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     c.RedisAddrs,
+			Username:  c.RedisUsername,
+			Password:  c.RedisPassword,
+			TLSConfig: redisTLSConfig(c),
+		})
 
-	return &c
+	default:
+		addr := ":" + strconv.Itoa(c.LandlordPort)
+		if len(c.RedisAddrs) == 1 {
+			addr = c.RedisAddrs[0]
+		}
+
+		return redis.NewClient(&redis.Options{
+			Addr:      addr,
+			Username:  c.RedisUsername,
+			Password:  c.RedisPassword,
+			DB:        c.RedisDB,
+			TLSConfig: redisTLSConfig(c),
+		})
+	}
 }
 
 func getKey(parts ...string) string {
 	return "landlord:" + strings.Join(parts, ":")
 }
 
-func refreshOccupiedPorts(c *redis.Conn) {
-	s := redis.NewScript(2, `
-		local tenants = redis.call("SMEMBERS", KEYS[1])
-		redis.call("DEL", KEYS[2])
-		for i = 1, #tenants do
-			local port = redis.call("GET", ARGV[1] .. ":" .. tenants[i] .. ":port")
-			redis.call("SADD", KEYS[2], port)
-		end
-	`)
-
-	if _, e := s.Do(*c,
-		getKey("tenants"),
-		getKey("ports", "occupied"),
-		getKey("tenant")); e != nil {
+var refreshOccupiedPortsScript = redis.NewScript(`
+	local tenants = redis.call("SMEMBERS", KEYS[1])
+	redis.call("DEL", KEYS[2])
+	for i = 1, #tenants do
+		local port = redis.call("GET", ARGV[1] .. ":" .. tenants[i] .. ":port")
+		redis.call("SADD", KEYS[2], port)
+	end
+`)
+
+func refreshOccupiedPorts(c redis.UniversalClient) {
+	if e := refreshOccupiedPortsScript.Run(ctx, c,
+		[]string{getKey("tenants"), getKey("ports", "occupied")},
+		getKey("tenant")).Err(); e != nil && e != redis.Nil {
 		log.Panic("Unable to refresh occupied ports", e)
 	}
+
+	updatePortGauges(c)
 }
 
-func getFreePort(c *redis.Conn) int {
+var getFreePortScript = redis.NewScript(`
+	redis.call("SDIFFSTORE", KEYS[3], KEYS[1], KEYS[2])
+	redis.call("ZINTERSTORE", KEYS[3], 1, KEYS[3])
+	local freePort = redis.call("ZRANGE", KEYS[3], 0, 0)[1]
+	redis.call("SADD", KEYS[2], freePort)
+	if freePort == nil then
+		return 0
+	else
+		return freePort
+	end
+`)
+
+func getFreePort(c redis.UniversalClient) int {
 	// refreshOccupiedPorts(c)
+	start := time.Now()
+
+	r, e := getFreePortScript.Run(ctx, c,
+		[]string{getKey("ports", "possible"), getKey("ports", "occupied"), getKey("ports", "available")}).Int()
+
+	opDuration.WithLabelValues("GetFreePort").Observe(time.Since(start).Seconds())
+	opLog("GetFreePort", "", start, r, e)
 
-	s := redis.NewScript(3, `
-		redis.call("SDIFFSTORE", KEYS[3], KEYS[1], KEYS[2])
-		redis.call("ZINTERSTORE", KEYS[3], 1, KEYS[3])
-		local freePort = redis.call("ZRANGE", KEYS[3], 0, 0)[1]
-		redis.call("SADD", KEYS[2], freePort)
-		if freePort == nil then
-			return 0
-		else
-			return freePort
-		end
-	`)
-
-	r, e := redis.Int(s.Do(*c,
-		getKey("ports", "possible"),
-		getKey("ports", "occupied"),
-		getKey("ports", "available")))
 	if e != nil {
 		log.Panic("Unable to fetch a free port: ", e)
 	}
 
+	updatePortGauges(c)
+
 	return r
 }
 
-func releasePort(c *redis.Conn, port int) {
+func releasePort(c redis.UniversalClient, port int) {
 	if port <= 0 {
 		return
 	}
 
 	log.Printf("Releasing port %d", port)
-	if _, e := (*c).Do("SREM", getKey("ports", "occupied"), port); e != nil {
+	if e := c.SRem(ctx, getKey("ports", "occupied"), port).Err(); e != nil {
 		log.Panicf("Unable to release port %d: %v", port, e)
 	}
 }
 
-func getPort(c *redis.Conn, id string) int {
+func getPort(c redis.UniversalClient, id string) int {
 	log.Printf("Getting port for %s", id)
-	r, e := redis.Int((*c).Do("GET", getKey("tenant", id, "port")))
+	r, e := c.Get(ctx, getKey("tenant", id, "port")).Int()
 	if e != nil {
 		log.Panicf("Unable to get port for %s: %v", id, e)
 	}
@@ -206,21 +316,44 @@ func getPort(c *redis.Conn, id string) int {
 	return r
 }
 
+// tryGetPort is getPort without the panic, for callers that must tolerate
+// one bad tenant without aborting the whole response.
+func tryGetPort(c redis.UniversalClient, id string) (port int, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = errors.New(panicMessage(e))
+		}
+	}()
+
+	return getPort(c, id), nil
+}
+
 func executeManagerOp(op string, id string, args ...string) (string, error) {
+	start := time.Now()
+
 	allArgs := make([]string, 0, len(args)+3)
 	allArgs = append(allArgs, cfg.ManagerPath, op, id)
 	allArgs = append(allArgs, args...)
 
-	log.Printf("Running sudo %s", strings.Join(allArgs, " "))
-
 	cmd := exec.Command("sudo", allArgs...)
 	cmd.Dir = path.Dir(cfg.ManagerPath)
 	output, e := cmd.CombinedOutput()
-	if e != nil {
-		log.Printf("Unable to run %s: %v", strings.Join(allArgs, " "), e)
+
+	exitCode := 0
+	if ee, ok := e.(*exec.ExitError); ok {
+		exitCode = ee.ExitCode()
 	}
 
-	log.Println(string(output))
+	logger.WithFields(logrus.Fields{
+		"op":          op,
+		"tenant_id":   id,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"exit_code":   exitCode,
+		"err":         errString(e),
+		"output":      strings.TrimSpace(string(output)),
+	}).Debug("manager op " + strings.Join(allArgs, " "))
+
+	managerExitCodeTotal.WithLabelValues(strconv.Itoa(exitCode)).Inc()
 
 	return string(output), e
 }
@@ -247,17 +380,24 @@ func parseManagerError(err string) ManagerError {
 	}
 }
 
-func setupInstance(id string) (rport int, err error) {
-	c := dial()
-	defer (*c).Close()
-
+func setupInstance(id string, meta TenantMeta) (rport int, err error) {
+	start := time.Now()
 	var port int
 
-	log.Printf("Setting up \"%s\"", id)
+	defer func() {
+		opLog("Setup", id, start, port, err)
+
+		status := "OK"
+		if err != nil {
+			status = "ERROR"
+		}
+		setupTotal.WithLabelValues(status).Inc()
+		opDuration.WithLabelValues("Setup").Observe(time.Since(start).Seconds())
+	}()
 
 	defer func() {
 		if e := recover(); e != nil {
-			releasePort(c, port)
+			releasePort(rdb, port)
 			rport = 0
 			switch v := e.(type) {
 			case error:
@@ -270,45 +410,61 @@ func setupInstance(id string) (rport int, err error) {
 		}
 	}()
 
-	port = getFreePort(c)
-	if _, e := executeManagerOp("setup", id, strconv.Itoa(port)); e != nil {
-		err := parseManagerError(e.Error())
-		log.Printf("parsed: %v", err)
-		if err.ExitCode != 7 {
+	port = getFreePort(rdb)
+	if _, e := executeManagerOp("setup", id, managerSetupArgs(port, meta)...); e != nil {
+		managerErr := parseManagerError(e.Error())
+		if managerErr.ExitCode != 7 {
 			if port == 0 {
 				panic(errors.New("No free ports. Increase MaxTenants, or set up a new Landlord server."))
 			}
 
-			panic(err)
+			panic(managerErr)
 		}
 
-		releasePort(c, port)
-		port = getPort(c, id)
+		// Already exists: the manager never applied meta to the running
+		// instance, so don't let it overwrite the quotas/TTL/labels that
+		// are actually in effect.
+		releasePort(rdb, port)
+		port = getPort(rdb, id)
+		return port, nil
 	}
 
+	saveTenantMeta(id, meta)
+
 	return port, nil
 }
 
-func deleteInstance(id string) error {
-	c := dial()
-	defer (*c).Close()
+func deleteInstance(id string) (err error) {
+	start := time.Now()
+
+	defer func() {
+		opLog("Delete", id, start, 0, err)
+
+		status := "OK"
+		if err != nil {
+			status = "ERROR"
+		}
+		deleteTotal.WithLabelValues(status).Inc()
+		opDuration.WithLabelValues("Delete").Observe(time.Since(start).Seconds())
+	}()
 
 	if _, e := executeManagerOp("delete", id); e != nil {
 		switch e.Error() {
 		case "exit status 9":
-			return errors.New("Instance does not exist.")
+			e = errors.New("Instance does not exist.")
 		}
 
-		return e
+		err = e
+		return err
 	}
 
+	deleteTenantMeta(id)
+	updatePortGauges(rdb)
+
 	return nil
 }
 
 func getExistingPort(id string) (rport int, err error) {
-	c := dial()
-	defer (*c).Close()
-
 	var port int
 
 	defer func() {
@@ -327,44 +483,53 @@ func getExistingPort(id string) (rport int, err error) {
 
 	log.Printf("Getting port for \"%s\"", id)
 
-	port = getPort(c, id)
+	port = getPort(rdb, id)
 	return port, nil
 }
 
 func dispatchResponse(recipient string, rsp interface{}) {
-	c := *dial()
-	defer c.Close()
-
 	log.Printf("Responding to %s: %v", recipient, rsp)
 	payload := toJson(rsp)
-	c.Do("PUBLISH", "landlord.response."+recipient, string(*payload))
+	rdb.Publish(ctx, "landlord.response."+recipient, string(*payload))
 }
 
-func readInstruction(msg *redis.Message) *Instruction {
+func readInstruction(data []byte) *Instruction {
 	var instr Instruction
-	if e := json.Unmarshal(msg.Data, &instr); e != nil {
-		log.Printf("Unable to read instruction \"%s\"; %v", msg.Data, e)
+	if e := json.Unmarshal(data, &instr); e != nil {
+		log.Printf("Unable to read instruction \"%s\"; %v", data, e)
 		return nil
 	}
 
 	return &instr
 }
 
-func handleInstruction(instr *Instruction) {
+func panicMessage(e interface{}) string {
+	switch v := e.(type) {
+	case error:
+		return v.Error()
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func isMutatingOp(op string) bool {
+	return op == "Setup" || op == "Delete"
+}
+
+// handleInstruction processes an instruction received on
+// "landlord.request.<replyTo>". forwarded is true when it instead arrived
+// on "landlord.leader.request", i.e. another node already decided it
+// couldn't handle it locally.
+func handleInstruction(instr *Instruction, forwarded bool) {
+	start := time.Now()
+
 	defer func() {
 		if e := recover(); e != nil {
-			log.Printf("Panic stopped: %v", e)
-			var msg string
-			switch v := e.(type) {
-			case error:
-				msg = v.Error()
-			case string:
-				msg = v
-			default:
-				msg = fmt.Sprintf("%v", v)
-			}
-
-			dispatchResponse(instr.ReplyTo, &PlainResponse{Status: "ERROR", Error: msg})
+			msg := panicMessage(e)
+			opLog(instr.Op, instr.Id, start, 0, errors.New(msg))
+			dispatchResponse(instr.ReplyTo, &PlainResponse{RequestId: instr.RequestId, Status: "ERROR", Error: msg})
 		}
 	}()
 
@@ -372,18 +537,59 @@ func handleInstruction(instr *Instruction) {
 		log.Panicf("Invalid id.")
 	}
 
-	log.Printf("Op: %s", instr.Op)
+	if isMutatingOp(instr.Op) && !isLeader() {
+		if forwarded {
+			if leaseHeld() {
+				// Someone else holds the lease and will handle this
+				// forward; replying here too would just race theirs.
+				opLog(instr.Op, instr.Id, start, 0, errors.New("not leader (any more); dropped"))
+				return
+			}
+
+			// Lease changed hands (or lapsed) between forwardToLeader's
+			// check and delivery here, so nobody is going to answer this
+			// the normal way. landlord.leader.request is fanned out to
+			// every node, so every non-leader observing the gap lands
+			// here at once; claim a per-request marker so only the first
+			// one actually replies, instead of the client getting one
+			// reply per non-leader node.
+			if !claimGapReply(instr.RequestId) {
+				opLog(instr.Op, instr.Id, start, 0, errors.New("no leader currently held the lease; another node already replied"))
+				return
+			}
+
+			opLog(instr.Op, instr.Id, start, 0, errors.New("no leader currently held the lease"))
+			dispatchResponse(instr.ReplyTo, &PlainResponse{Id: instr.Id, RequestId: instr.RequestId, Status: "ERROR", Error: "No Landlord node currently holds leadership; try again shortly."})
+			return
+		}
+
+		if !forwardToLeader(instr) {
+			opLog(instr.Op, instr.Id, start, 0, errors.New("no leader currently held the lease"))
+			dispatchResponse(instr.ReplyTo, &PlainResponse{Id: instr.Id, RequestId: instr.RequestId, Status: "ERROR", Error: "No Landlord node currently holds leadership; try again shortly."})
+		}
+
+		return
+	}
+
+	var opErr error
+	var port int
 
 	switch instr.Op {
 	case "Setup":
 		rsp := &SetupResponse{Status: "OK"}
 		rsp.Id = instr.Id
-		port, e := setupInstance(instr.Id)
-		if e != nil {
+		rsp.RequestId = instr.RequestId
+		meta := TenantMeta{Id: instr.Id, MaxMemory: instr.MaxMemory, MaxClients: instr.MaxClients, TTL: instr.TTL, Labels: instr.Labels}
+		port, opErr = setupInstance(instr.Id, meta)
+		if opErr != nil {
 			rsp.Status = "ERROR"
-			rsp.Error = e.Error()
+			rsp.Error = opErr.Error()
 		} else {
 			rsp.Port = port
+			rsp.MaxMemory = instr.MaxMemory
+			rsp.MaxClients = instr.MaxClients
+			rsp.TTL = instr.TTL
+			rsp.Labels = instr.Labels
 		}
 
 		dispatchResponse(instr.ReplyTo, rsp)
@@ -391,9 +597,10 @@ func handleInstruction(instr *Instruction) {
 	case "Delete":
 		rsp := &PlainResponse{Status: "OK"}
 		rsp.Id = instr.Id
-		if e := deleteInstance(instr.Id); e != nil {
+		rsp.RequestId = instr.RequestId
+		if opErr = deleteInstance(instr.Id); opErr != nil {
 			rsp.Status = "ERROR"
-			rsp.Error = e.Error()
+			rsp.Error = opErr.Error()
 		}
 
 		dispatchResponse(instr.ReplyTo, rsp)
@@ -401,81 +608,208 @@ func handleInstruction(instr *Instruction) {
 	case "GetPort":
 		rsp := &SetupResponse{Status: "OK"}
 		rsp.Id = instr.Id
-		port, e := getExistingPort(instr.Id)
-		if e != nil {
+		rsp.RequestId = instr.RequestId
+		port, opErr = getExistingPort(instr.Id)
+		if opErr != nil {
 			rsp.Status = "ERROR"
-			rsp.Error = e.Error()
+			rsp.Error = opErr.Error()
 		} else {
 			rsp.Port = port
 		}
 
 		dispatchResponse(instr.ReplyTo, rsp)
 
+	case "List":
+		rsp := &ListResponse{Status: "OK", RequestId: instr.RequestId}
+		tenants, e := listTenants()
+		opErr = e
+		if opErr != nil {
+			rsp.Status = "ERROR"
+			rsp.Error = opErr.Error()
+		} else {
+			rsp.Tenants = tenants
+		}
+
+		dispatchResponse(instr.ReplyTo, rsp)
+
 	default:
-		log.Printf("Unknown op: %s", instr.Op)
+		opErr = fmt.Errorf("unknown operation %q", instr.Op)
 
-		rsp := &PlainResponse{Status: "ERROR", Error: "Unknown operation."}
+		rsp := &PlainResponse{RequestId: instr.RequestId, Status: "ERROR", Error: "Unknown operation."}
 		dispatchResponse(instr.ReplyTo, rsp)
 
 	}
+
+	// Setup and Delete instrument and log themselves (so reapOnce's direct
+	// deleteInstance calls are covered too); avoid double-counting/logging
+	// them here.
+	if instr.Op != "Setup" && instr.Op != "Delete" {
+		opDuration.WithLabelValues(instr.Op).Observe(time.Since(start).Seconds())
+		opLog(instr.Op, instr.Id, start, port, opErr)
+	}
+}
+
+const requestChannelPrefix = "landlord.request."
+
+func spawnHandler(instr *Instruction, forwarded bool) {
+	handlerWg.Add(1)
+	go func() {
+		defer handlerWg.Done()
+		handleInstruction(instr, forwarded)
+	}()
 }
 
 func listen() {
-	c := *dial()
-	defer c.Close()
+	sub := rdb.Subscribe(ctx, "landlord.leader.request")
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	if e := sub.PSubscribe(ctx, requestChannelPrefix+"*"); e != nil {
+		log.Panicf("Unable to psubscribe to %s*: %v", requestChannelPrefix, e)
+	}
 
-	psc := redis.PubSubConn{c}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Shutting down: unsubscribing from Pub/Sub")
+			sub.Close()
+			handlerWg.Wait()
+			return
+
+		case msg, ok := <-ch:
+			if !ok {
+				handlerWg.Wait()
+				return
+			}
 
-	go func() {
-		psc.Subscribe("landlord.request")
-		defer wg.Done()
-		for {
-			switch v := psc.Receive().(type) {
-			case redis.Message:
-				log.Printf("%s: message %s", v.Channel, v.Data)
-				if instr := readInstruction(&v); instr != nil {
-					go handleInstruction(instr)
+			log.Printf("%s: message %s", msg.Channel, msg.Payload)
+
+			if msg.Channel == "landlord.leader.request" {
+				if instr := readInstruction([]byte(msg.Payload)); instr != nil {
+					spawnHandler(instr, true)
 				}
 
-				// If we can't read the instruction, we don't know to whom we
-				// should respond. This is a slight problem, which could be solved
-				// by using a pattern subscription and embedding the ReplyTo in
-				// the channel name.
-			case error:
-				log.Printf("Receive fail; %v", v)
-				return
+				continue
+			}
+
+			// The reply-to id lives in the channel suffix, so even a malformed
+			// payload can still be answered: we always know who to respond to.
+			replyTo := strings.TrimPrefix(msg.Channel, requestChannelPrefix)
+
+			instr := readInstruction([]byte(msg.Payload))
+			if instr == nil {
+				dispatchResponse(replyTo, &PlainResponse{RequestId: replyTo, Status: "ERROR", Error: "Unable to parse instruction."})
+				continue
 			}
+
+			instr.ReplyTo = replyTo
+			if instr.RequestId == "" {
+				instr.RequestId = replyTo
+			}
+
+			spawnHandler(instr, false)
 		}
-	}()
+	}
+}
+
+// forwardToLeader re-publishes a mutating instruction on
+// "landlord.leader.request", which only the current leader acts on. It
+// reports false without forwarding when no node currently holds the
+// lease, so the caller can respond with an error instead of a silent drop.
+func forwardToLeader(instr *Instruction) bool {
+	if !leaseHeld() {
+		return false
+	}
 
-	wg.Wait()
-	return
+	rdb.Publish(ctx, "landlord.leader.request", string(*toJson(instr)))
+	return true
 }
 
+var prepareDbScript = redis.NewScript(`
+  redis.call("DEL", KEYS[1])
+  local portBase = tonumber(ARGV[1])
+	local maxPorts = tonumber(ARGV[2])
+	for i = 0, maxPorts - 1 do
+	  redis.call("SADD", KEYS[1], portBase + i)
+	end
+`)
+
 func prepareDb() {
-	c := dial()
-	defer (*c).Close()
-
-	s := redis.NewScript(1, `
-	  redis.call("DEL", KEYS[1])
-	  local portBase = tonumber(ARGV[1])
-		local maxPorts = tonumber(ARGV[2])
-		for i = 0, maxPorts - 1 do
-		  redis.call("SADD", KEYS[1], portBase + i)
-		end
-	`)
-
-	if _, e := s.Do(*c,
-		getKey("ports", "possible"),
-		cfg.TenantPortBase,
-		cfg.MaxTenants); e != nil {
+	if e := prepareDbScript.Run(ctx, rdb,
+		[]string{getKey("ports", "possible")},
+		cfg.TenantPortBase, cfg.MaxTenants).Err(); e != nil && e != redis.Nil {
 		log.Panicf("Unable to prepare database: %v", e)
 	}
 
-	refreshOccupiedPorts(c)
+	reconcileRunningTenants()
+	refreshOccupiedPorts(rdb)
+}
+
+// parseRunningTenants turns the manager's "list" output ("<id> <port>" per
+// line) into a map of running tenant id to port, tolerating lines with no
+// port.
+func parseRunningTenants(output string) map[string]int {
+	running := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		port := 0
+		if len(fields) > 1 {
+			port, _ = strconv.Atoi(fields[1])
+		}
+
+		running[fields[0]] = port
+	}
+
+	return running
+}
+
+// reconcileRunningTenants asks the manager which instances are actually
+// running and repairs landlord:tenants so a crash mid-setup (or mid-delete)
+// can't leave a tenant, and its port, permanently leaked or orphaned.
+func reconcileRunningTenants() {
+	output, e := executeManagerOp("list", "")
+	if e != nil {
+		log.Printf("Unable to reconcile tenants: %v", e)
+		return
+	}
+
+	running := parseRunningTenants(output)
+
+	registered, e := rdb.SMembers(ctx, getKey("tenants")).Result()
+	if e != nil {
+		log.Printf("Unable to read registered tenants: %v", e)
+		return
+	}
+
+	registeredSet := make(map[string]bool, len(registered))
+	for _, id := range registered {
+		registeredSet[id] = true
+	}
+
+	for id, port := range running {
+		if registeredSet[id] {
+			continue
+		}
+
+		log.Printf("Reconcile: registering untracked running tenant %s (port %d)", id, port)
+		rdb.SAdd(ctx, getKey("tenants"), id)
+		if port > 0 {
+			rdb.Set(ctx, getKey("tenant", id, "port"), port, 0)
+		}
+	}
+
+	for _, id := range registered {
+		if _, ok := running[id]; ok {
+			continue
+		}
+
+		log.Printf("Reconcile: %s is registered but not running; removing stale entry", id)
+		rdb.SRem(ctx, getKey("tenants"), id)
+		deleteTenantMeta(id)
+	}
 }
 
 func initLogging() {
@@ -485,15 +819,45 @@ func initLogging() {
 		panic(errors.New(fmt.Sprintf("Unable to open log file %s: %v", cfg.LogPath, e)))
 	}
 
-	log.SetOutput(io.MultiWriter(logf, os.Stdout))
+	out := io.MultiWriter(logf, os.Stdout)
+	log.SetOutput(out)
+	logger.SetOutput(out)
 }
 
 func main() {
 	cfg = *readConfig()
 	initLogging()
 	log.Printf("Config: %v", cfg)
-	prepareDb()
+	rdb = newRedisClient(&cfg)
+	defer rdb.Close()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-sigCh
+		log.Printf("Received %v, shutting down", s)
+		cancelCtx()
+	}()
+
+	httpDone := make(chan struct{})
+	go func() {
+		defer close(httpDone)
+		listenHTTP()
+	}()
+
+	bgWg.Add(2)
+	go func() {
+		defer bgWg.Done()
+		reapExpiredTenants()
+	}()
+	go func() {
+		defer bgWg.Done()
+		startLeaderElection()
+	}()
+
 	listen()
+	<-httpDone
+	bgWg.Wait()
 
 	log.Println("Exited")
 	fmt.Println("Good bye.")
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseManagerError(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantCode int
+	}{
+		{"exit status 7", 7},
+		{"exit status 9", 9},
+		{"exit status 99", 99},
+		{"connection refused", 0},
+	}
+
+	for _, c := range cases {
+		got := parseManagerError(c.in)
+		if got.ExitCode != c.wantCode {
+			t.Errorf("parseManagerError(%q).ExitCode = %d, want %d", c.in, got.ExitCode, c.wantCode)
+		}
+	}
+}
+
+func TestParseRunningTenants(t *testing.T) {
+	output := "tenant-a 6381\ntenant-b 6382\n\ntenant-c"
+	want := map[string]int{
+		"tenant-a": 6381,
+		"tenant-b": 6382,
+		"tenant-c": 0,
+	}
+
+	if got := parseRunningTenants(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRunningTenants(%q) = %v, want %v", output, got, want)
+	}
+}
+
+func TestParseRunningTenantsEmpty(t *testing.T) {
+	if got := parseRunningTenants(""); len(got) != 0 {
+		t.Errorf("parseRunningTenants(\"\") = %v, want empty", got)
+	}
+}
+
+func TestGetKey(t *testing.T) {
+	if got := getKey("tenant", "foo", "meta"); got != "landlord:tenant:foo:meta" {
+		t.Errorf("getKey(...) = %q, want %q", got, "landlord:tenant:foo:meta")
+	}
+}
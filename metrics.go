@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var setupTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "landlord_setup_total",
+	Help: "Tenant setup attempts, by result status.",
+}, []string{"status"})
+
+var deleteTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "landlord_delete_total",
+	Help: "Tenant delete attempts, by result status.",
+}, []string{"status"})
+
+var opDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "landlord_op_duration_seconds",
+	Help: "Duration of tenant operations, by op.",
+}, []string{"op"})
+
+var freePorts = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "landlord_free_ports",
+	Help: "Number of ports currently available for new tenants.",
+})
+
+var occupiedPorts = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "landlord_occupied_ports",
+	Help: "Number of ports currently occupied by tenants.",
+})
+
+var managerExitCodeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "landlord_manager_exit_code_total",
+	Help: "Manager invocations, by exit code.",
+}, []string{"code"})
+
+func init() {
+	prometheus.MustRegister(setupTotal, deleteTotal, opDuration, freePorts, occupiedPorts, managerExitCodeTotal)
+}
+
+// updatePortGauges refreshes the free/occupied port gauges. Free ports are
+// computed as possible minus occupied rather than read from
+// landlord:ports:available, since that set is only a getFreePort scratch
+// key and isn't kept in sync by reconciliation or leader transitions.
+func updatePortGauges(c redis.UniversalClient) {
+	if free, e := c.SDiff(ctx, getKey("ports", "possible"), getKey("ports", "occupied")).Result(); e == nil {
+		freePorts.Set(float64(len(free)))
+	}
+
+	if occupied, e := c.SCard(ctx, getKey("ports", "occupied")).Result(); e == nil {
+		occupiedPorts.Set(float64(occupied))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+const reapInterval = 30 * time.Second
+
+func managerSetupArgs(port int, meta TenantMeta) []string {
+	args := []string{strconv.Itoa(port)}
+
+	if meta.MaxMemory > 0 {
+		args = append(args, "maxmemory", strconv.FormatInt(meta.MaxMemory, 10))
+	}
+
+	if meta.MaxClients > 0 {
+		args = append(args, "maxclients", strconv.Itoa(meta.MaxClients))
+	}
+
+	return args
+}
+
+func saveTenantMeta(id string, meta TenantMeta) {
+	var expiresAt int64
+	if meta.TTL > 0 {
+		expiresAt = time.Now().Unix() + int64(meta.TTL)
+	}
+
+	labels, e := json.Marshal(meta.Labels)
+	if e != nil {
+		log.Panicf("Unable to marshal labels for %s: %v", id, e)
+	}
+
+	fields := map[string]interface{}{
+		"maxmemory":  meta.MaxMemory,
+		"maxclients": meta.MaxClients,
+		"ttl":        meta.TTL,
+		"expiresat":  expiresAt,
+		"labels":     string(labels),
+	}
+
+	if e := rdb.HSet(ctx, getKey("tenant", id, "meta"), fields).Err(); e != nil {
+		log.Panicf("Unable to save metadata for %s: %v", id, e)
+	}
+}
+
+func deleteTenantMeta(id string) {
+	if e := rdb.Del(ctx, getKey("tenant", id, "meta")).Err(); e != nil {
+		log.Printf("Unable to delete metadata for %s: %v", id, e)
+	}
+}
+
+// loadTenantMeta reads back the metadata hash for a tenant. expiresAt is
+// returned alongside for the reaper; it isn't part of the public TenantMeta.
+func loadTenantMeta(id string) (meta TenantMeta, expiresAt int64, err error) {
+	fields, e := rdb.HGetAll(ctx, getKey("tenant", id, "meta")).Result()
+	if e != nil {
+		return meta, 0, e
+	}
+
+	meta.Id = id
+
+	if v, ok := fields["maxmemory"]; ok {
+		meta.MaxMemory, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	if v, ok := fields["maxclients"]; ok {
+		meta.MaxClients, _ = strconv.Atoi(v)
+	}
+
+	if v, ok := fields["ttl"]; ok {
+		meta.TTL, _ = strconv.Atoi(v)
+	}
+
+	if v, ok := fields["expiresat"]; ok {
+		expiresAt, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	if v, ok := fields["labels"]; ok && v != "" {
+		json.Unmarshal([]byte(v), &meta.Labels)
+	}
+
+	return meta, expiresAt, nil
+}
+
+func listTenants() ([]TenantMeta, error) {
+	ids, e := rdb.SMembers(ctx, getKey("tenants")).Result()
+	if e != nil {
+		return nil, e
+	}
+
+	tenants := make([]TenantMeta, 0, len(ids))
+	for _, id := range ids {
+		meta, _, e := loadTenantMeta(id)
+		if e != nil {
+			log.Printf("Unable to load metadata for %s: %v", id, e)
+			continue
+		}
+
+		port, e := tryGetPort(rdb, id)
+		if e != nil {
+			log.Printf("Unable to get port for %s: %v", id, e)
+			continue
+		}
+
+		meta.Port = port
+		tenants = append(tenants, meta)
+	}
+
+	return tenants, nil
+}
+
+func reapExpiredTenants() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reapOnce()
+		}
+	}
+}
+
+func reapOnce() {
+	if !isLeader() {
+		return
+	}
+
+	ids, e := rdb.SMembers(ctx, getKey("tenants")).Result()
+	if e != nil {
+		log.Printf("Unable to list tenants for reaping: %v", e)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, id := range ids {
+		_, expiresAt, e := loadTenantMeta(id)
+		if e != nil {
+			log.Printf("Unable to load metadata for %s: %v", id, e)
+			continue
+		}
+
+		if expiresAt <= 0 || now < expiresAt {
+			continue
+		}
+
+		log.Printf("TTL expired for %s, deleting", id)
+		if e := deleteInstance(id); e != nil {
+			log.Printf("Unable to reap %s: %v", id, e)
+		}
+	}
+}
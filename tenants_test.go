@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestManagerSetupArgs(t *testing.T) {
+	args := managerSetupArgs(6381, TenantMeta{MaxMemory: 1048576, MaxClients: 10})
+	want := []string{"6381", "maxmemory", "1048576", "maxclients", "10"}
+
+	if len(args) != len(want) {
+		t.Fatalf("managerSetupArgs(...) = %v, want %v", args, want)
+	}
+
+	for i := range want {
+		if args[i] != want[i] {
+			t.Errorf("managerSetupArgs(...)[%d] = %q, want %q", i, args[i], want[i])
+		}
+	}
+}
+
+func TestManagerSetupArgsNoQuotas(t *testing.T) {
+	args := managerSetupArgs(6381, TenantMeta{})
+	want := []string{"6381"}
+
+	if len(args) != len(want) || args[0] != want[0] {
+		t.Errorf("managerSetupArgs(...) = %v, want %v", args, want)
+	}
+}